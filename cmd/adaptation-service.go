@@ -1,20 +1,58 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
-	"log"
+	"math/rand"
 	"os"
+	"os/signal"
+	"strconv"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"io/ioutil"
+	"net"
+	"net/http"
 	"net/url"
+	"strings"
+	"sync"
 
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/promlog"
 
 	pod "github.com/icap-adaptation-service/pkg"
+	"github.com/icap-adaptation-service/pkg/config"
+	"github.com/icap-adaptation-service/pkg/idle"
 	"github.com/streadway/amqp"
 )
 
+const consumerTag = "adaptation-service"
+
+const defaultShutdownGraceSeconds = 30
+
+const (
+	minBackoff = 1 * time.Second
+	maxBackoff = 30 * time.Second
+
+	retryMinBackoff = 500 * time.Millisecond
+	retryMaxBackoff = 10 * time.Second
+
+	publishConfirmTimeout = 5 * time.Second
+)
+
+const (
+	defaultHTTPListenAddr      = ":8080"
+	defaultReadyProbeStaleness = 60 * time.Second
+)
+
 const (
 	ok        = "ok"
 	jsonerr   = "json_error"
@@ -23,10 +61,6 @@ const (
 )
 
 var (
-	exchange   = "adaptation-exchange"
-	routingKey = "adaptation-request"
-	queueName  = "adaptation-request-queue"
-
 	procTime = promauto.NewHistogram(
 		prometheus.HistogramOpts{
 			Name:    "gw_adaptation_message_processing_time_millisecond",
@@ -40,112 +74,559 @@ var (
 			Name: "gw_adaptation_messages_consumed_total",
 			Help: "Number of messages consumed from Rabbit",
 		},
-		[]string{"status"},
+		[]string{"status", "exchange"},
+	)
+
+	retriesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gw_adaptation_retries_total",
+			Help: "Number of transient k8s client or pod creation failures retried",
+		},
+		[]string{"exchange"},
+	)
+
+	deadletteredTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gw_adaptation_deadlettered_total",
+			Help: "Number of messages republished to the dead-letter queue",
+		},
+		[]string{"reason", "exchange"},
 	)
 
-	podNamespace                          = os.Getenv("POD_NAMESPACE")
-	inputMount                            = os.Getenv("INPUT_MOUNT")
-	outputMount                           = os.Getenv("OUTPUT_MOUNT")
-	requestProcessingImage                = os.Getenv("REQUEST_PROCESSING_IMAGE")
-	requestProcessingTimeout              = os.Getenv("REQUEST_PROCESSING_TIMEOUT")
-	adaptationRequestQueueHostname        = os.Getenv("ADAPTATION_REQUEST_QUEUE_HOSTNAME")
-	adaptationRequestQueuePort            = os.Getenv("ADAPTATION_REQUEST_QUEUE_PORT")
+	// archive/transaction queue settings are only ever forwarded into the
+	// processing pod's own env, never used by this process's AMQP
+	// connection, so they stay plain env reads rather than config fields.
 	archiveAdaptationRequestQueueHostname = os.Getenv("ARCHIVE_ADAPTATION_QUEUE_REQUEST_HOSTNAME")
 	archiveAdaptationRequestQueuePort     = os.Getenv("ARCHIVE_ADAPTATION_REQUEST_QUEUE_PORT")
 	transactionEventQueueHostname         = os.Getenv("TRANSACTION_EVENT_QUEUE_HOSTNAME")
 	transactionEventQueuePort             = os.Getenv("TRANSACTION_EVENT_QUEUE_PORT")
-	messagebrokeruser                     = os.Getenv("MESSAGE_BROKER_USER")
-	messagebrokerpassword                 = os.Getenv("MESSAGE_BROKER_PASSWORD")
-	cpuLimit                              = os.Getenv("CPU_LIMIT")
-	cpuRequest                            = os.Getenv("CPU_REQUEST")
-	memoryLimit                           = os.Getenv("MEMORY_LIMIT")
-	memoryRequest                         = os.Getenv("MEMORY_REQUEST")
+
+	logger log.Logger
+
+	podTracker  = idle.NewTracker()
+	svcReady    = &readiness{}
+	loggerValue atomic.Value // holds log.Logger, swapped on SIGHUP
+	liveConfig  atomic.Value // holds *config.Config, swapped on SIGHUP
 )
 
-func main() {
-	if podNamespace == "" || inputMount == "" || outputMount == "" {
-		log.Fatalf("init failed: POD_NAMESPACE, INPUT_MOUNT or OUTPUT_MOUNT environment variables not set")
+// readiness tracks the signals the /readyz probe needs: whether every
+// adaptation's AMQP connection is open, and how long ago the Kubernetes
+// client last completed a discovery call. It is keyed by queue name so one
+// adaptation reconnecting doesn't mask another being down, even when
+// several adaptations share an exchange.
+type readiness struct {
+	mu            sync.RWMutex
+	conns         map[string]*amqp.Connection
+	lastDiscovery time.Time
+}
+
+func (r *readiness) setConn(exchange string, conn *amqp.Connection) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conns == nil {
+		r.conns = make(map[string]*amqp.Connection)
 	}
+	r.conns[exchange] = conn
+}
+
+func (r *readiness) markDiscovery(t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastDiscovery = t
+}
 
-	if adaptationRequestQueueHostname == "" || archiveAdaptationRequestQueueHostname == "" || transactionEventQueueHostname == "" {
-		log.Fatalf("init failed: ADAPTATION_REQUEST_QUEUE_HOSTNAME, ARCHIVE_ADAPTATION_QUEUE_REQUEST_HOSTNAME or TRANSACTION_EVENT_QUEUE_HOSTNAME environment variables not set")
+func (r *readiness) ready(staleness time.Duration, adaptationCount int) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.conns) < adaptationCount {
+		return false
+	}
+	for _, conn := range r.conns {
+		if conn == nil || conn.IsClosed() {
+			return false
+		}
 	}
+	if r.lastDiscovery.IsZero() {
+		return false
+	}
+	return time.Since(r.lastDiscovery) <= staleness
+}
+
+// dynamicLogger dereferences loggerValue on every call, so swapping it (on
+// SIGHUP, when LOG_LEVEL changes live) takes effect for every logger built
+// from it, including ones already captured by log.With.
+type dynamicLogger struct{}
 
-	if adaptationRequestQueuePort == "" || archiveAdaptationRequestQueuePort == "" || transactionEventQueuePort == "" {
-		log.Fatalf("init failed: ADAPTATION_REQUEST_QUEUE_PORT, ARCHIVE_ADAPTATION_REQUEST_QUEUE_PORT or TRANSACTION_EVENT_QUEUE_PORT environment variables not set")
+func (dynamicLogger) Log(keyvals ...interface{}) error {
+	return loggerValue.Load().(log.Logger).Log(keyvals...)
+}
+
+// setLogLevel rebuilds the filtered base logger for levelValue (one of
+// debug|info|warn|error) and swaps it into loggerValue.
+func setLogLevel(levelValue string) {
+	if levelValue == "" {
+		levelValue = "info"
 	}
 
-	if cpuLimit == "" || cpuRequest == "" || memoryLimit == "" || memoryRequest == "" {
-		log.Fatalf("init failed: CPU_LIMIT, CPU_REQUEST, MEMORY_LIMIT or MEMORY_REQUEST environment variables not set")
+	allowedLevel := &promlog.AllowedLevel{}
+	if err := allowedLevel.Set(levelValue); err != nil {
+		allowedLevel = &promlog.AllowedLevel{}
+		_ = allowedLevel.Set("info")
 	}
 
-	if messagebrokeruser == "" {
-		messagebrokeruser = "guest"
+	l := promlog.New(&promlog.Config{Level: allowedLevel})
+	loggerValue.Store(log.With(l, "ts", log.DefaultTimestampUTC, "caller", log.DefaultCaller))
+}
+
+// currentConfig returns the live, possibly SIGHUP-reloaded configuration.
+func currentConfig() *config.Config {
+	return liveConfig.Load().(*config.Config)
+}
+
+func main() {
+	configFile := os.Getenv("CONFIG_FILE")
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "init failed: %v\n", err)
+		os.Exit(1)
 	}
+	liveConfig.Store(cfg)
+
+	setLogLevel(cfg.LogLevel)
+	logger = dynamicLogger{}
 
-	if messagebrokerpassword == "" {
-		messagebrokerpassword = "guest"
+	if cfg.PodNamespace == "" || cfg.InputMount == "" || cfg.OutputMount == "" {
+		level.Error(logger).Log("msg", "init failed", "reason", "podNamespace, inputMount or outputMount not set")
+		os.Exit(1)
 	}
 
-	amqpUrl := url.URL{
-		Scheme: "amqp",
-		User:   url.UserPassword(messagebrokeruser, messagebrokerpassword),
-		Host:   fmt.Sprintf("%s:%s", adaptationRequestQueueHostname, adaptationRequestQueuePort),
-		Path:   "/",
+	if cfg.MessageBroker.Hostname == "" || archiveAdaptationRequestQueueHostname == "" || transactionEventQueueHostname == "" {
+		level.Error(logger).Log("msg", "init failed", "reason", "ADAPTATION_REQUEST_QUEUE_HOSTNAME, ARCHIVE_ADAPTATION_QUEUE_REQUEST_HOSTNAME or TRANSACTION_EVENT_QUEUE_HOSTNAME environment variables not set")
+		os.Exit(1)
 	}
-	fmt.Println("Connecting to ", amqpUrl.Host)
 
-	conn, err := amqp.Dial(amqpUrl.String())
-	failOnError(err, fmt.Sprintf("Failed to connect to %s", amqpUrl.Host))
-	defer conn.Close()
+	if cfg.MessageBroker.Port == "" || archiveAdaptationRequestQueuePort == "" || transactionEventQueuePort == "" {
+		level.Error(logger).Log("msg", "init failed", "reason", "ADAPTATION_REQUEST_QUEUE_PORT, ARCHIVE_ADAPTATION_REQUEST_QUEUE_PORT or TRANSACTION_EVENT_QUEUE_PORT environment variables not set")
+		os.Exit(1)
+	}
 
-	ch, err := conn.Channel()
-	failOnError(err, "Failed to open a channel")
-	defer ch.Close()
+	if cfg.Resources.CPULimit == "" || cfg.Resources.CPURequest == "" || cfg.Resources.MemoryLimit == "" || cfg.Resources.MemoryRequest == "" {
+		level.Error(logger).Log("msg", "init failed", "reason", "CPU_LIMIT, CPU_REQUEST, MEMORY_LIMIT or MEMORY_REQUEST environment variables not set")
+		os.Exit(1)
+	}
+
+	if len(cfg.Adaptations) == 0 {
+		level.Error(logger).Log("msg", "init failed", "reason", "no adaptations configured")
+		os.Exit(1)
+	}
+
+	installConfigReloader(configFile)
+
+	readyProbeStaleness := defaultReadyProbeStaleness
+	if v := os.Getenv("READY_PROBE_STALENESS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			readyProbeStaleness = time.Duration(parsed) * time.Second
+		}
+	}
+
+	httpListenAddr := os.Getenv("HTTP_LISTEN_ADDR")
+	if httpListenAddr == "" {
+		httpListenAddr = defaultHTTPListenAddr
+	}
 
-	err = ch.ExchangeDeclare(exchange, "direct", true, false, false, false, nil)
-	failOnError(err, "Failed to declare an exchange")
+	srv := newAdminServer(httpListenAddr, readyProbeStaleness, len(cfg.Adaptations))
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			level.Error(logger).Log("msg", "admin HTTP server stopped unexpectedly", "err", err)
+		}
+	}()
+	level.Info(logger).Log("msg", "serving /healthz, /readyz and /metrics", "addr", httpListenAddr)
 
-	q, err := ch.QueueDeclare(queueName, false, false, false, false, nil)
-	failOnError(err, "Failed to declare a queue")
+	ctx, stop := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-sigs
+		level.Info(logger).Log("msg", "shutdown signal received", "signal", sig.String())
+		stop()
+	}()
 
-	err = ch.QueueBind(q.Name, routingKey, exchange, false, nil)
-	failOnError(err, "Failed to bind queue")
+	var adaptations sync.WaitGroup
+	for _, adaptation := range cfg.Adaptations {
+		adaptations.Add(1)
+		go func(a config.Adaptation) {
+			defer adaptations.Done()
+			runSupervisor(ctx, srv, a, cfg.Worker.PrefetchCount, cfg.Worker.Concurrency)
+		}(adaptation)
+	}
+	adaptations.Wait()
 
-	msgs, err := ch.Consume(q.Name, "", true, false, false, false, nil)
-	failOnError(err, "Failed to register a consumer")
+	level.Info(logger).Log("msg", "shutdown complete")
+}
 
-	forever := make(chan bool)
+// installConfigReloader installs a SIGHUP handler that re-reads
+// configFile and atomically swaps in the subset of config that is safe to
+// change live: log level, retry policy and the resource limits applied to
+// newly created pods. Connection-level settings (the message broker, and
+// each adaptation's exchange/queue/routingKey) are only read once at
+// startup; picking those up would require a supervised reconnect, which is
+// not triggered here.
+func installConfigReloader(configFile string) {
+	if configFile == "" {
+		return
+	}
 
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
 	go func() {
-		for d := range msgs {
-			requeue, err := processMessage(d)
+		for range sighup {
+			cfg, err := config.Load(configFile)
 			if err != nil {
-				log.Printf("Failed to process message: %v", err)
-				ch.Nack(d.DeliveryTag, false, requeue)
+				level.Error(logger).Log("msg", "failed to reload config file", "file", configFile, "err", err)
+				continue
 			}
+			next := *currentConfig()
+			next.LogLevel = cfg.LogLevel
+			next.RetryPolicy = cfg.RetryPolicy
+			next.Resources = cfg.Resources
+			liveConfig.Store(&next)
+			setLogLevel(cfg.LogLevel)
+			level.Info(logger).Log("msg", "reloaded config", "log_level", cfg.LogLevel, "max_retries", cfg.RetryPolicy.MaxRetries, "cpu_limit", cfg.Resources.CPULimit, "memory_limit", cfg.Resources.MemoryLimit)
 		}
 	}()
+}
+
+// newAdminServer builds the /healthz, /readyz and /metrics mux used for
+// Kubernetes liveness/readiness probes and Prometheus scraping.
+func newAdminServer(addr string, readyProbeStaleness time.Duration, adaptationCount int) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !svcReady.ready(readyProbeStaleness, adaptationCount) {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	return &http.Server{Addr: addr, Handler: mux}
+}
+
+// runSupervisor owns the AMQP connection lifecycle for one adaptation. It
+// (re)connects, re-declares the exchange/queue/bindings and fans deliveries
+// out across a pool of workerConcurrency workers until the delivery channel
+// closes because the channel or connection was closed out from under it or
+// the consumer was cancelled, then backs off with jitter and reconnects. It
+// returns once ctx is cancelled and drain has finished, which waits for the
+// in-flight pod tracker only up to the shutdown grace period.
+func runSupervisor(ctx context.Context, srv *http.Server, adaptation config.Adaptation, prefetchCount, workerConcurrency int) {
+	attempt := 0
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		conn, ch, dlq, msgs, err := connect(adaptation, prefetchCount)
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to connect to message broker", "exchange", adaptation.Exchange, "attempt", attempt, "err", err)
+			if !sleepWithBackoff(ctx, attempt) {
+				return
+			}
+			attempt++
+			continue
+		}
+		attempt = 0
+		svcReady.setConn(adaptation.QueueName, conn)
+
+		closed := make(chan struct{})
+		var workers sync.WaitGroup
+		for i := 0; i < workerConcurrency; i++ {
+			workers.Add(1)
+			go func() {
+				defer workers.Done()
+				for d := range msgs {
+					processDelivery(ch, dlq, d, adaptation)
+				}
+			}()
+		}
+		go func() {
+			workers.Wait()
+			close(closed)
+		}()
+
+		level.Info(logger).Log("msg", "waiting for messages, press CTRL+C to exit", "exchange", adaptation.Exchange, "queue", adaptation.QueueName, "worker_concurrency", workerConcurrency)
+
+		select {
+		case <-ctx.Done():
+			drain(srv, ch, conn, closed)
+			return
+		case <-closed:
+			level.Error(logger).Log("msg", "lost connection to message broker, reconnecting", "exchange", adaptation.Exchange)
+			conn.Close()
+		}
+	}
+}
+
+// processDelivery runs a single delivery through processMessage, retrying
+// transient pod-creation failures with backoff, and acks, nacks or
+// dead-letters the delivery accordingly.
+func processDelivery(ch *amqp.Channel, dlq *dlqPublisher, d amqp.Delivery, adaptation config.Adaptation) {
+	maxRetries := currentConfig().RetryPolicy.MaxRetries
+	requeue, err := processMessageWithRetry(ch, dlq, d, adaptation, maxRetries)
+	if err != nil {
+		level.Error(logger).Log("msg", "failed to process message", "delivery-tag", d.DeliveryTag, "err", err)
+		ch.Nack(d.DeliveryTag, false, requeue)
+		return
+	}
+	ch.Ack(d.DeliveryTag, false)
+}
+
+// connect dials the broker, declares the adaptation's exchange/queue/binding,
+// applies QoS prefetch, puts the channel into publisher-confirm mode and
+// starts consuming with manual acknowledgements. It is called once per
+// connection attempt so a reconnect always re-declares topology.
+func connect(adaptation config.Adaptation, prefetchCount int) (*amqp.Connection, *amqp.Channel, *dlqPublisher, <-chan amqp.Delivery, error) {
+	broker := currentConfig().MessageBroker
+	amqpURL := brokerURL(broker)
+	level.Info(logger).Log("msg", "connecting to message broker", "host", amqpURL.Host, "tls", broker.TLS, "exchange", adaptation.Exchange)
+
+	var conn *amqp.Connection
+	var err error
+	if broker.TLS {
+		tlsConfig, tlsErr := brokerTLSConfig(broker.CABundle)
+		if tlsErr != nil {
+			return nil, nil, nil, nil, fmt.Errorf("failed to build TLS config: %v", tlsErr)
+		}
+		conn, err = amqp.DialTLS(amqpURL.String(), tlsConfig)
+	} else {
+		conn, err = amqp.Dial(amqpURL.String())
+	}
+	if err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("failed to connect to %s: %v", amqpURL.Host, err)
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, nil, fmt.Errorf("failed to open a channel: %v", err)
+	}
+
+	if err := ch.Confirm(false); err != nil {
+		conn.Close()
+		return nil, nil, nil, nil, fmt.Errorf("failed to put channel into confirm mode: %v", err)
+	}
+	dlqPub := &dlqPublisher{ch: ch, confirms: ch.NotifyPublish(make(chan amqp.Confirmation, 1))}
+
+	if err := ch.ExchangeDeclare(adaptation.Exchange, "direct", true, false, false, false, nil); err != nil {
+		conn.Close()
+		return nil, nil, nil, nil, fmt.Errorf("failed to declare an exchange: %v", err)
+	}
+
+	dlqExchange, dlqQueueName, dlqRoutingKey := dlqNames(adaptation)
 
-	log.Printf("[*] Waiting for messages. To exit press CTRL+C")
-	<-forever
+	if err := ch.ExchangeDeclare(dlqExchange, "direct", true, false, false, false, nil); err != nil {
+		conn.Close()
+		return nil, nil, nil, nil, fmt.Errorf("failed to declare the dead-letter exchange: %v", err)
+	}
+
+	dlq, err := ch.QueueDeclare(dlqQueueName, false, false, false, false, nil)
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, nil, fmt.Errorf("failed to declare the dead-letter queue: %v", err)
+	}
+
+	if err := ch.QueueBind(dlq.Name, dlqRoutingKey, dlqExchange, false, nil); err != nil {
+		conn.Close()
+		return nil, nil, nil, nil, fmt.Errorf("failed to bind dead-letter queue: %v", err)
+	}
+
+	q, err := ch.QueueDeclare(adaptation.QueueName, false, false, false, false, amqp.Table{
+		"x-dead-letter-exchange": dlqExchange,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, nil, fmt.Errorf("failed to declare a queue: %v", err)
+	}
+
+	if err := ch.QueueBind(q.Name, adaptation.RoutingKey, adaptation.Exchange, false, nil); err != nil {
+		conn.Close()
+		return nil, nil, nil, nil, fmt.Errorf("failed to bind queue: %v", err)
+	}
+
+	if err := ch.Qos(prefetchCount, 0, false); err != nil {
+		conn.Close()
+		return nil, nil, nil, nil, fmt.Errorf("failed to set QoS prefetch: %v", err)
+	}
+
+	msgs, err := ch.Consume(q.Name, consumerTag, false, false, false, false, nil)
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, nil, fmt.Errorf("failed to register a consumer: %v", err)
+	}
+
+	return conn, ch, dlqPub, msgs, nil
+}
+
+// dlqPublisher serializes dead-letter publishes on a channel shared across
+// worker goroutines. amqp.Confirmation values aren't correlated back to the
+// publish that produced them, so without the mutex two workers publishing
+// concurrently could each read back the other's confirmation.
+type dlqPublisher struct {
+	mu       sync.Mutex
+	ch       *amqp.Channel
+	confirms <-chan amqp.Confirmation
 }
 
-func failOnError(err error, msg string) {
+// publish sends msg to exchange/routingKey and waits for the broker to
+// confirm it, returning false (never an error) if the broker nacked,
+// returned or never confirmed the publish within publishConfirmTimeout.
+func (p *dlqPublisher) publish(exchange, routingKey string, msg amqp.Publishing) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.ch.Publish(exchange, routingKey, false, false, msg); err != nil {
+		return false, err
+	}
+
+	select {
+	case confirmation, ok := <-p.confirms:
+		return ok && confirmation.Ack, nil
+	case <-time.After(publishConfirmTimeout):
+		return false, nil
+	}
+}
+
+// dlqNames derives a dead-letter exchange, queue and routing key from an
+// adaptation's own queue name, so each adaptation dead-letters into its own
+// queue instead of a single queue shared (and thus ambiguous) across every
+// adaptation an instance is configured to run.
+func dlqNames(adaptation config.Adaptation) (exchange, queueName, routingKey string) {
+	dlqName := adaptation.QueueName + "-dlq"
+	return dlqName + "-exchange", dlqName, dlqName
+}
+
+func brokerURL(broker config.MessageBroker) url.URL {
+	scheme := "amqp"
+	if broker.TLS {
+		scheme = "amqps"
+	}
+	return url.URL{
+		Scheme: scheme,
+		User:   url.UserPassword(broker.User, broker.Password),
+		Host:   fmt.Sprintf("%s:%s", broker.Hostname, broker.Port),
+		Path:   "/",
+	}
+}
+
+// brokerTLSConfig builds a tls.Config trusting the CA bundle at caBundle,
+// for use with amqps:// brokers.
+func brokerTLSConfig(caBundle string) (*tls.Config, error) {
+	if caBundle == "" {
+		return &tls.Config{}, nil
+	}
+
+	caCert, err := ioutil.ReadFile(caBundle)
 	if err != nil {
-		log.Fatalf("%s: %s", msg, err)
+		return nil, fmt.Errorf("failed to read CA bundle %s: %v", caBundle, err)
+	}
+
+	caCertPool := x509.NewCertPool()
+	if !caCertPool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("failed to parse CA bundle %s", caBundle)
+	}
+
+	return &tls.Config{RootCAs: caCertPool}, nil
+}
+
+// sleepWithBackoff waits an exponentially increasing, jittered delay between
+// reconnect attempts, returning false if ctx is cancelled first.
+func sleepWithBackoff(ctx context.Context, attempt int) bool {
+	backoff := minBackoff * time.Duration(1<<uint(attempt))
+	if backoff > maxBackoff || backoff <= 0 {
+		backoff = maxBackoff
+	}
+	jitter := time.Duration(rand.Int63n(int64(backoff) / 2))
+	delay := backoff/2 + jitter
+
+	select {
+	case <-time.After(delay):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// drain stops serving the admin HTTP server, cancels the consumer so no new
+// deliveries arrive, waits up to SHUTDOWN_GRACE_SECONDS for workers to
+// finish the deliveries (and any GetClient/CreatePod retries) they're
+// currently processing, then waits out whatever remains of that same grace
+// period for in-flight pod creations to finish, before closing the channel
+// and connection. It makes the process safe to stop mid rolling deploy:
+// Kubernetes gets a bounded shutdown either way, even if a worker is still
+// stuck on a delivery when the grace period expires.
+func drain(srv *http.Server, ch *amqp.Channel, conn *amqp.Connection, closed chan struct{}) {
+	graceSeconds := defaultShutdownGraceSeconds
+	if v := os.Getenv("SHUTDOWN_GRACE_SECONDS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil {
+			graceSeconds = parsed
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(graceSeconds)*time.Second)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		level.Error(logger).Log("msg", "failed to shut down admin HTTP server", "err", err)
+	}
+
+	if err := ch.Cancel(consumerTag, false); err != nil {
+		level.Error(logger).Log("msg", "failed to cancel consumer", "err", err)
+	}
+
+	select {
+	case <-closed:
+	case <-ctx.Done():
+		level.Error(logger).Log("msg", "shutdown grace period expired while workers were still processing deliveries, proceeding anyway", "grace_seconds", graceSeconds)
+	}
+
+	level.Info(logger).Log("msg", "waiting for in-flight pods to finish", "count", podTracker.Count(), "grace_seconds", graceSeconds)
+	if err := podTracker.WaitIdle(ctx); err != nil {
+		level.Error(logger).Log("msg", "shutdown grace period expired with pods still in flight", "count", podTracker.Count(), "err", err)
 	}
+
+	ch.Close()
+	conn.Close()
 }
 
-func processMessage(d amqp.Delivery) (bool, error) {
-	defer func(start time.Time) {
+// processMessageWithRetry builds the pod for a delivery and retries
+// transient GetClient and CreatePod failures with backoff, up to maxRetries
+// times each. Once retries are exhausted, the failure is not transient, or
+// the delivery's headers are malformed, the delivery is republished to the
+// dead-letter queue and the caller is told to ack the original so it is not
+// redelivered - unless the dead-letter publish itself could not be
+// confirmed, in which case the caller is told to nack-with-requeue instead,
+// since acking would otherwise drop the message with no record of it
+// anywhere.
+func processMessageWithRetry(ch *amqp.Channel, dlq *dlqPublisher, d amqp.Delivery, adaptation config.Adaptation, maxRetries int) (bool, error) {
+	start := time.Now()
+	defer func() {
 		procTime.Observe(float64(time.Since(start).Milliseconds()))
-	}(time.Now())
+	}()
 
 	if d.Headers["file-id"] == nil ||
 		d.Headers["source-file-location"] == nil ||
 		d.Headers["rebuilt-file-location"] == nil {
-		return false, fmt.Errorf("Headers value is nil")
+		err := fmt.Errorf("Headers value is nil")
+		msgTotal.WithLabelValues(jsonerr, adaptation.Exchange).Inc()
+		level.Error(logger).Log("msg", "malformed message headers, dead-lettering", "delivery-tag", d.DeliveryTag, "duration_ms", time.Since(start).Milliseconds(), "outcome", jsonerr, "err", err)
+		if !deadLetter(dlq, d, adaptation, err, 1, time.Now(), "malformed_headers") {
+			return true, err
+		}
+		return false, nil
 	}
 
 	fileID := d.Headers["file-id"].(string)
@@ -157,45 +638,183 @@ func processMessage(d amqp.Delivery) (bool, error) {
 		generateReport = d.Headers["generate-report"].(string)
 	}
 
-	log.Printf("Received a message for file: %s", fileID)
+	cfg := currentConfig()
+	msgLogger := log.With(logger, "file-id", fileID, "delivery-tag", d.DeliveryTag, "pod-namespace", cfg.PodNamespace)
+	level.Info(msgLogger).Log("msg", "received message")
 
 	podArgs := pod.PodArgs{
-		PodNamespace:                          podNamespace,
+		PodNamespace:                          cfg.PodNamespace,
 		FileID:                                fileID,
 		Input:                                 input,
 		Output:                                output,
 		GenerateReport:                        generateReport,
-		InputMount:                            inputMount,
-		OutputMount:                           outputMount,
+		InputMount:                            cfg.InputMount,
+		OutputMount:                           cfg.OutputMount,
 		ReplyTo:                               d.ReplyTo,
-		RequestProcessingImage:                requestProcessingImage,
-		RequestProcessingTimeout:              requestProcessingTimeout,
-		AdaptationRequestQueueHostname:        adaptationRequestQueueHostname,
-		AdaptationRequestQueuePort:            adaptationRequestQueuePort,
+		RequestProcessingImage:                adaptation.Image,
+		RequestProcessingTimeout:              adaptation.Timeout,
+		AdaptationRequestQueueHostname:        cfg.MessageBroker.Hostname,
+		AdaptationRequestQueuePort:            cfg.MessageBroker.Port,
 		ArchiveAdaptationRequestQueueHostname: archiveAdaptationRequestQueueHostname,
 		ArchiveAdaptationRequestQueuePort:     archiveAdaptationRequestQueuePort,
 		TransactionEventQueueHostname:         transactionEventQueueHostname,
 		TransactionEventQueuePort:             transactionEventQueuePort,
-		MessageBrokerUser:                     messagebrokeruser,
-		MessageBrokerPassword:                 messagebrokerpassword,
-		CPULimit:                              cpuLimit,
-		CPURequest:                            cpuRequest,
-		MemoryLimit:                           memoryLimit,
-		MemoryRequest:                         memoryRequest,
+		MessageBrokerUser:                     cfg.MessageBroker.User,
+		MessageBrokerPassword:                 cfg.MessageBroker.Password,
+		CPULimit:                              cfg.Resources.CPULimit,
+		CPURequest:                            cfg.Resources.CPURequest,
+		MemoryLimit:                           cfg.Resources.MemoryLimit,
+		MemoryRequest:                         cfg.Resources.MemoryRequest,
 	}
 
-	err := podArgs.GetClient()
-	if err != nil {
-		msgTotal.WithLabelValues(k8sclient).Inc()
-		return true, fmt.Errorf("Failed to get client for cluster: %v", err)
+	var clientFirstFailure time.Time
+	clientAttempts := 0
+	for {
+		clientAttempts++
+
+		err := podArgs.GetClient()
+		if err == nil {
+			break
+		}
+
+		if clientFirstFailure.IsZero() {
+			clientFirstFailure = time.Now()
+		}
+
+		if !isTransientError(err) || clientAttempts > maxRetries {
+			reason := "max_retries_exceeded"
+			if !isTransientError(err) {
+				reason = "non_transient_error"
+			}
+			msgTotal.WithLabelValues(k8sclient, adaptation.Exchange).Inc()
+			level.Error(msgLogger).Log("msg", "failed to get client for cluster, dead-lettering", "attempts", clientAttempts, "reason", reason, "duration_ms", time.Since(start).Milliseconds(), "outcome", k8sclient, "err", err)
+			if !deadLetter(dlq, d, adaptation, err, clientAttempts, clientFirstFailure, reason) {
+				return true, err
+			}
+			return false, nil
+		}
+
+		retriesTotal.WithLabelValues(adaptation.Exchange).Inc()
+		level.Error(msgLogger).Log("msg", "transient k8s client error, retrying", "attempt", clientAttempts, "max_retries", maxRetries, "err", err)
+		time.Sleep(retryBackoff(clientAttempts))
 	}
+	svcReady.markDiscovery(time.Now())
+
+	var firstFailure time.Time
+	attempts := 0
+	for {
+		attempts++
+
+		podTracker.Increment()
+		err := podArgs.CreatePod()
+		podTracker.Decrement()
+		if err == nil {
+			break
+		}
 
-	err = podArgs.CreatePod()
-	if err != nil {
-		msgTotal.WithLabelValues(k8sapi).Inc()
-		return true, fmt.Errorf("Failed to create pod: %v", err)
+		if firstFailure.IsZero() {
+			firstFailure = time.Now()
+		}
+
+		if !isTransientError(err) || attempts > maxRetries {
+			reason := "max_retries_exceeded"
+			if !isTransientError(err) {
+				reason = "non_transient_error"
+			}
+			msgTotal.WithLabelValues(k8sapi, adaptation.Exchange).Inc()
+			level.Error(msgLogger).Log("msg", "failed to create pod, dead-lettering", "attempts", attempts, "reason", reason, "duration_ms", time.Since(start).Milliseconds(), "outcome", k8sapi, "err", err)
+			if !deadLetter(dlq, d, adaptation, err, attempts, firstFailure, reason) {
+				return true, err
+			}
+			return false, nil
+		}
+
+		retriesTotal.WithLabelValues(adaptation.Exchange).Inc()
+		level.Error(msgLogger).Log("msg", "transient pod creation failure, retrying", "attempt", attempts, "max_retries", maxRetries, "err", err)
+		time.Sleep(retryBackoff(attempts))
 	}
 
-	msgTotal.WithLabelValues(ok).Inc()
+	msgTotal.WithLabelValues(ok, adaptation.Exchange).Inc()
+	level.Info(msgLogger).Log("msg", "pod created", "attempts", attempts, "duration_ms", time.Since(start).Milliseconds(), "outcome", ok)
 	return false, nil
 }
+
+// isTransientError reports whether err looks like a transient network or
+// overloaded-server failure (connection errors, timeouts, HTTP 429, 500,
+// 502, 503 and 504 responses from the Kubernetes API) that is worth
+// retrying. It matches on the error text; other 5xx statuses are not
+// treated as transient.
+func isTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	msg := strings.ToLower(err.Error())
+	transientSubstrings := []string{
+		"connection refused", "connection reset", "i/o timeout", "timeout",
+		"too many requests", "429",
+		"internal server error", "500",
+		"bad gateway", "502",
+		"service unavailable", "503",
+		"gateway timeout", "504",
+		"eof",
+	}
+	for _, substr := range transientSubstrings {
+		if strings.Contains(msg, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryBackoff returns an exponential delay, capped at retryMaxBackoff, for
+// the given retry attempt (1-indexed).
+func retryBackoff(attempt int) time.Duration {
+	backoff := retryMinBackoff * time.Duration(1<<uint(attempt-1))
+	if backoff > retryMaxBackoff || backoff <= 0 {
+		backoff = retryMaxBackoff
+	}
+	return backoff
+}
+
+// deadLetter republishes a delivery that could not be processed to the
+// dead-letter exchange, carrying diagnostic headers so operators can see why
+// and how many times it was attempted without having to correlate logs. It
+// reports false, without incrementing deadletteredTotal, if the publish
+// itself failed or the broker never confirmed it, so the caller can nack
+// the original delivery for redelivery instead of acking a message that was
+// never safely dead-lettered.
+func deadLetter(dlq *dlqPublisher, d amqp.Delivery, adaptation config.Adaptation, cause error, attempts int, firstFailure time.Time, reason string) bool {
+	dlqExchange, _, dlqRoutingKey := dlqNames(adaptation)
+
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers["x-original-error"] = cause.Error()
+	headers["x-attempts"] = attempts
+	headers["x-first-failure-time"] = firstFailure.UTC().Format(time.RFC3339)
+
+	confirmed, err := dlq.publish(dlqExchange, dlqRoutingKey, amqp.Publishing{
+		Headers:     headers,
+		ContentType: d.ContentType,
+		Body:        d.Body,
+		ReplyTo:     d.ReplyTo,
+	})
+	if err != nil {
+		level.Error(logger).Log("msg", "failed to publish to dead-letter queue", "delivery-tag", d.DeliveryTag, "err", err)
+		return false
+	}
+	if !confirmed {
+		level.Error(logger).Log("msg", "dead-letter publish was not confirmed by broker", "delivery-tag", d.DeliveryTag)
+		return false
+	}
+
+	deadletteredTotal.WithLabelValues(reason, adaptation.Exchange).Inc()
+	return true
+}
@@ -0,0 +1,189 @@
+// Package config loads the adaptation service's runtime configuration from
+// a YAML file, with environment variables retained as overrides for
+// backward compatibility with the original flat env-var configuration.
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Default topology used when no CONFIG_FILE / adaptations list is supplied,
+// matching the service's original single-queue behaviour.
+const (
+	DefaultExchange   = "adaptation-exchange"
+	DefaultRoutingKey = "adaptation-request"
+	DefaultQueueName  = "adaptation-request-queue"
+)
+
+// RetryPolicy controls how transient pod-creation failures are retried
+// before a message is dead-lettered. It is safe to change live.
+type RetryPolicy struct {
+	MaxRetries int `yaml:"maxRetries"`
+}
+
+// Resources are the per-pod resource requests/limits applied to pods
+// created for new messages. It is safe to change live; pods already
+// running are unaffected.
+type Resources struct {
+	CPURequest    string `yaml:"cpuRequest"`
+	CPULimit      string `yaml:"cpuLimit"`
+	MemoryRequest string `yaml:"memoryRequest"`
+	MemoryLimit   string `yaml:"memoryLimit"`
+}
+
+// MessageBroker holds the AMQP connection settings. These are
+// connection-level: changing them requires a supervised reconnect rather
+// than a live config swap.
+type MessageBroker struct {
+	Hostname string `yaml:"hostname"`
+	Port     string `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	TLS      bool   `yaml:"tls"`
+	CABundle string `yaml:"caBundle"`
+}
+
+// Adaptation is one {exchange, routingKey, queueName, image} tuple the
+// service consumes from and dispatches to. A single instance can run
+// several of these concurrently, each processed by its own image.
+type Adaptation struct {
+	Exchange   string `yaml:"exchange"`
+	RoutingKey string `yaml:"routingKey"`
+	QueueName  string `yaml:"queueName"`
+	Image      string `yaml:"image"`
+	Timeout    string `yaml:"timeout"`
+}
+
+// Config is the full runtime configuration for the adaptation service.
+type Config struct {
+	PodNamespace  string        `yaml:"podNamespace"`
+	InputMount    string        `yaml:"inputMount"`
+	OutputMount   string        `yaml:"outputMount"`
+	LogLevel      string        `yaml:"logLevel"`
+	Worker        WorkerConfig  `yaml:"worker"`
+	RetryPolicy   RetryPolicy   `yaml:"retryPolicy"`
+	Resources     Resources     `yaml:"resources"`
+	MessageBroker MessageBroker `yaml:"messageBroker"`
+	Adaptations   []Adaptation  `yaml:"adaptations"`
+}
+
+// WorkerConfig sizes the consumer. Concurrency and prefetch are read once
+// at startup; a config reload does not change them.
+type WorkerConfig struct {
+	Concurrency   int `yaml:"concurrency"`
+	PrefetchCount int `yaml:"prefetchCount"`
+}
+
+// Load reads path (if non-empty) as YAML into a Config seeded with
+// defaults, then applies environment variable overrides on top so existing
+// deployments that only set env vars keep working unchanged.
+func Load(path string) (*Config, error) {
+	cfg := &Config{
+		Worker: WorkerConfig{Concurrency: 4, PrefetchCount: 10},
+		RetryPolicy: RetryPolicy{
+			MaxRetries: 3,
+		},
+		Adaptations: []Adaptation{
+			{Exchange: DefaultExchange, RoutingKey: DefaultRoutingKey, QueueName: DefaultQueueName},
+		},
+	}
+
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file %s: %v", path, err)
+		}
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %v", path, err)
+		}
+	}
+
+	applyEnvOverrides(cfg)
+	return cfg, nil
+}
+
+// applyEnvOverrides maps the service's original flat env vars onto cfg, so
+// a config file is optional and existing deployments are unaffected.
+func applyEnvOverrides(cfg *Config) {
+	if v := os.Getenv("POD_NAMESPACE"); v != "" {
+		cfg.PodNamespace = v
+	}
+	if v := os.Getenv("INPUT_MOUNT"); v != "" {
+		cfg.InputMount = v
+	}
+	if v := os.Getenv("OUTPUT_MOUNT"); v != "" {
+		cfg.OutputMount = v
+	}
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		cfg.LogLevel = v
+	}
+	if cfg.LogLevel == "" {
+		cfg.LogLevel = "info"
+	}
+
+	if v := os.Getenv("WORKER_CONCURRENCY"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			cfg.Worker.Concurrency = parsed
+		}
+	}
+	if v := os.Getenv("AMQP_PREFETCH_COUNT"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			cfg.Worker.PrefetchCount = parsed
+		}
+	}
+	if v := os.Getenv("MAX_RETRIES"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed >= 0 {
+			cfg.RetryPolicy.MaxRetries = parsed
+		}
+	}
+
+	if v := os.Getenv("CPU_REQUEST"); v != "" {
+		cfg.Resources.CPURequest = v
+	}
+	if v := os.Getenv("CPU_LIMIT"); v != "" {
+		cfg.Resources.CPULimit = v
+	}
+	if v := os.Getenv("MEMORY_REQUEST"); v != "" {
+		cfg.Resources.MemoryRequest = v
+	}
+	if v := os.Getenv("MEMORY_LIMIT"); v != "" {
+		cfg.Resources.MemoryLimit = v
+	}
+
+	if v := os.Getenv("ADAPTATION_REQUEST_QUEUE_HOSTNAME"); v != "" {
+		cfg.MessageBroker.Hostname = v
+	}
+	if v := os.Getenv("ADAPTATION_REQUEST_QUEUE_PORT"); v != "" {
+		cfg.MessageBroker.Port = v
+	}
+	if v := os.Getenv("MESSAGE_BROKER_USER"); v != "" {
+		cfg.MessageBroker.User = v
+	}
+	if cfg.MessageBroker.User == "" {
+		cfg.MessageBroker.User = "guest"
+	}
+	if v := os.Getenv("MESSAGE_BROKER_PASSWORD"); v != "" {
+		cfg.MessageBroker.Password = v
+	}
+	if cfg.MessageBroker.Password == "" {
+		cfg.MessageBroker.Password = "guest"
+	}
+	if os.Getenv("MESSAGE_BROKER_TLS") == "true" {
+		cfg.MessageBroker.TLS = true
+	}
+	if v := os.Getenv("MESSAGE_BROKER_CA_BUNDLE"); v != "" {
+		cfg.MessageBroker.CABundle = v
+	}
+
+	if v := os.Getenv("REQUEST_PROCESSING_IMAGE"); v != "" && len(cfg.Adaptations) == 1 {
+		cfg.Adaptations[0].Image = v
+	}
+	if v := os.Getenv("REQUEST_PROCESSING_TIMEOUT"); v != "" && len(cfg.Adaptations) == 1 {
+		cfg.Adaptations[0].Timeout = v
+	}
+}
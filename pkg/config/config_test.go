@@ -0,0 +1,139 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadDefaultsWithNoPath(t *testing.T) {
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Load() = %v, want nil", err)
+	}
+
+	if got, want := len(cfg.Adaptations), 1; got != want {
+		t.Fatalf("len(Adaptations) = %d, want %d", got, want)
+	}
+	if got := cfg.Adaptations[0].Exchange; got != DefaultExchange {
+		t.Errorf("Adaptations[0].Exchange = %q, want %q", got, DefaultExchange)
+	}
+	if got := cfg.Worker.Concurrency; got != 4 {
+		t.Errorf("Worker.Concurrency = %d, want 4", got)
+	}
+	if got := cfg.RetryPolicy.MaxRetries; got != 3 {
+		t.Errorf("RetryPolicy.MaxRetries = %d, want 3", got)
+	}
+	if got := cfg.MessageBroker.User; got != "guest" {
+		t.Errorf("MessageBroker.User = %q, want %q", got, "guest")
+	}
+}
+
+func TestLoadYAMLOverridesDefaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	yaml := `
+podNamespace: rebuild
+worker:
+  concurrency: 8
+retryPolicy:
+  maxRetries: 5
+adaptations:
+  - exchange: rebuild-exchange
+    routingKey: rebuild-request
+    queueName: rebuild-request-queue
+    image: rebuild:latest
+`
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() = %v, want nil", err)
+	}
+
+	if got := cfg.PodNamespace; got != "rebuild" {
+		t.Errorf("PodNamespace = %q, want %q", got, "rebuild")
+	}
+	if got := cfg.Worker.Concurrency; got != 8 {
+		t.Errorf("Worker.Concurrency = %d, want 8", got)
+	}
+	if got := cfg.RetryPolicy.MaxRetries; got != 5 {
+		t.Errorf("RetryPolicy.MaxRetries = %d, want 5", got)
+	}
+	if got, want := len(cfg.Adaptations), 1; got != want {
+		t.Fatalf("len(Adaptations) = %d, want %d", got, want)
+	}
+	if got := cfg.Adaptations[0].Image; got != "rebuild:latest" {
+		t.Errorf("Adaptations[0].Image = %q, want %q", got, "rebuild:latest")
+	}
+}
+
+func TestLoadMissingFileReturnsError(t *testing.T) {
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Fatal("Load() = nil, want an error for a missing config file")
+	}
+}
+
+func TestEnvOverridesTakePrecedenceOverYAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	yaml := "podNamespace: rebuild\nworker:\n  concurrency: 8\n"
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	t.Setenv("POD_NAMESPACE", "from-env")
+	t.Setenv("WORKER_CONCURRENCY", "16")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() = %v, want nil", err)
+	}
+
+	if got := cfg.PodNamespace; got != "from-env" {
+		t.Errorf("PodNamespace = %q, want %q (env should win over YAML)", got, "from-env")
+	}
+	if got := cfg.Worker.Concurrency; got != 16 {
+		t.Errorf("Worker.Concurrency = %d, want 16 (env should win over YAML)", got)
+	}
+}
+
+func TestSingleAdaptationEnvOverridesOnlyApplyWithOneAdaptation(t *testing.T) {
+	t.Setenv("REQUEST_PROCESSING_IMAGE", "from-env:latest")
+	t.Setenv("REQUEST_PROCESSING_TIMEOUT", "30s")
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Load() = %v, want nil", err)
+	}
+	if got := cfg.Adaptations[0].Image; got != "from-env:latest" {
+		t.Errorf("single adaptation Image = %q, want %q", got, "from-env:latest")
+	}
+	if got := cfg.Adaptations[0].Timeout; got != "30s" {
+		t.Errorf("single adaptation Timeout = %q, want %q", got, "30s")
+	}
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	yaml := `
+adaptations:
+  - exchange: a-exchange
+    routingKey: a-request
+    queueName: a-queue
+    image: a:latest
+  - exchange: b-exchange
+    routingKey: b-request
+    queueName: b-queue
+    image: b:latest
+`
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err = Load(path)
+	if err != nil {
+		t.Fatalf("Load() = %v, want nil", err)
+	}
+	if got := cfg.Adaptations[0].Image; got != "a:latest" {
+		t.Errorf("with multiple adaptations, Adaptations[0].Image = %q, want unchanged %q", got, "a:latest")
+	}
+}
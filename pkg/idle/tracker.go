@@ -0,0 +1,75 @@
+// Package idle tracks work that is currently in flight so callers can wait
+// for it to drain before shutting down, analogous to podman's
+// pkg/api/server/idle/tracker.go.
+package idle
+
+import (
+	"context"
+	"sync"
+)
+
+// Tracker counts in-flight units of work and lets callers block until the
+// count returns to zero.
+type Tracker struct {
+	mu    sync.Mutex
+	count int
+	idle  chan struct{}
+}
+
+// NewTracker returns a Tracker with a zero in-flight count.
+func NewTracker() *Tracker {
+	return &Tracker{idle: closedChan()}
+}
+
+// Increment records that a new unit of work has started.
+func (t *Tracker) Increment() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.count == 0 {
+		t.idle = make(chan struct{})
+	}
+	t.count++
+}
+
+// Decrement records that a unit of work has finished.
+func (t *Tracker) Decrement() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.count == 0 {
+		return
+	}
+	t.count--
+	if t.count == 0 {
+		close(t.idle)
+	}
+}
+
+// Count returns the current number of in-flight units of work.
+func (t *Tracker) Count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.count
+}
+
+// WaitIdle blocks until the in-flight count reaches zero or ctx is done,
+// whichever happens first.
+func (t *Tracker) WaitIdle(ctx context.Context) error {
+	t.mu.Lock()
+	idle := t.idle
+	t.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func closedChan() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}
@@ -0,0 +1,96 @@
+package idle
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNewTrackerStartsIdle(t *testing.T) {
+	tr := NewTracker()
+
+	if got := tr.Count(); got != 0 {
+		t.Fatalf("Count() = %d, want 0", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := tr.WaitIdle(ctx); err != nil {
+		t.Fatalf("WaitIdle() = %v, want nil", err)
+	}
+}
+
+func TestIncrementDecrementCount(t *testing.T) {
+	tr := NewTracker()
+
+	tr.Increment()
+	tr.Increment()
+	if got := tr.Count(); got != 2 {
+		t.Fatalf("Count() = %d, want 2", got)
+	}
+
+	tr.Decrement()
+	if got := tr.Count(); got != 1 {
+		t.Fatalf("Count() = %d, want 1", got)
+	}
+
+	tr.Decrement()
+	if got := tr.Count(); got != 0 {
+		t.Fatalf("Count() = %d, want 0", got)
+	}
+}
+
+func TestDecrementBelowZeroIsNoOp(t *testing.T) {
+	tr := NewTracker()
+
+	tr.Decrement()
+	if got := tr.Count(); got != 0 {
+		t.Fatalf("Count() = %d, want 0", got)
+	}
+}
+
+func TestWaitIdleBlocksUntilDecrement(t *testing.T) {
+	tr := NewTracker()
+	tr.Increment()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := tr.WaitIdle(ctx); err == nil {
+		t.Fatal("WaitIdle() = nil, want a deadline error while work is in flight")
+	}
+
+	tr.Decrement()
+
+	ctx2, cancel2 := context.WithTimeout(context.Background(), time.Second)
+	defer cancel2()
+	if err := tr.WaitIdle(ctx2); err != nil {
+		t.Fatalf("WaitIdle() = %v, want nil once drained", err)
+	}
+}
+
+// TestWaitIdleRecreatesChannelAfterReachingZero exercises the
+// zero-to-non-zero race: once the count has returned to zero and its idle
+// channel has closed, a fresh Increment must replace it with a new,
+// unclosed channel rather than leaving WaitIdle looking at a closed one.
+func TestWaitIdleRecreatesChannelAfterReachingZero(t *testing.T) {
+	tr := NewTracker()
+
+	tr.Increment()
+	tr.Decrement()
+
+	tr.Increment()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := tr.WaitIdle(ctx); err == nil {
+		t.Fatal("WaitIdle() = nil, want a deadline error while work is in flight again")
+	}
+
+	tr.Decrement()
+
+	ctx2, cancel2 := context.WithTimeout(context.Background(), time.Second)
+	defer cancel2()
+	if err := tr.WaitIdle(ctx2); err != nil {
+		t.Fatalf("WaitIdle() = %v, want nil once drained again", err)
+	}
+}